@@ -0,0 +1,237 @@
+package safety
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	uuid "github.com/nu7hatch/gouuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Token is a credential issued by an Auth implementation. Clients present
+// Token.ID back to the fake server (under whatever scheme the Auth was
+// registered with) on every RPC, and the server resolves it back to the
+// full Token to authenticate and authorize the call.
+type Token struct {
+	ID       string
+	Subject  string
+	Created  time.Time
+	Expiry   time.Time
+	Roles    []string
+	Metadata map[string]string
+}
+
+// Expired reports whether t has an expiry set and it has passed.
+func (t *Token) Expired() bool {
+	return !t.Expiry.IsZero() && time.Now().After(t.Expiry)
+}
+
+// HasRole reports whether t was issued with the given role.
+func (t *Token) HasRole(role string) bool {
+	for _, r := range t.Roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Auth mints, checks and invalidates tokens for the fake server. Swap in a
+// different implementation via WithAuth to exercise auth flows beyond a
+// single shared secret, e.g. per-subject bearer tokens that can expire or
+// be revoked mid-test.
+type Auth interface {
+	// Generate mints a new token for subject.
+	Generate(subject string) (*Token, error)
+	// Verify checks that t is a valid, unexpired, unrevoked token. On
+	// success it fills in the remaining fields of t (subject, roles,
+	// metadata) from the token record it was issued with.
+	Verify(t *Token) error
+	// Revoke invalidates t so future Verify calls for it fail.
+	Revoke(t *Token) error
+}
+
+// PolicyFunc is called after a token has been verified for an RPC, and can
+// reject the call based on the resolved token (e.g. its roles) or the
+// method being invoked. Returning a non-nil error denies the call.
+type PolicyFunc func(ctx context.Context, method string, tok *Token) error
+
+type tokenContextKey struct{}
+
+// TokenFromContext returns the Token that authenticated the current RPC,
+// if any.
+func TokenFromContext(ctx context.Context) (*Token, bool) {
+	tok, ok := ctx.Value(tokenContextKey{}).(*Token)
+	return tok, ok
+}
+
+func contextWithToken(ctx context.Context, tok *Token) context.Context {
+	return context.WithValue(ctx, tokenContextKey{}, tok)
+}
+
+// authFunc builds the grpc_auth.AuthFunc used by the unary and stream
+// interceptors to authenticate an incoming RPC against s's configured Auth.
+func (s *FakeServer) authFunc() grpc_auth.AuthFunc {
+	return func(ctx context.Context) (context.Context, error) {
+		raw, err := grpc_auth.AuthFromMD(ctx, s.authScheme)
+		if err != nil {
+			return nil, fmt.Errorf("could not extract token from request header: %w", err)
+		}
+
+		tok := &Token{ID: raw}
+		if err := s.authImpl.Verify(tok); err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "invalid auth token: %v", err)
+		}
+
+		if s.authPolicy != nil {
+			method, _ := grpc.Method(ctx)
+			if err := s.authPolicy(ctx, method, tok); err != nil {
+				return nil, status.Errorf(codes.PermissionDenied, "denied by auth policy: %v", err)
+			}
+		}
+
+		grpc_ctxtags.Extract(ctx).Set("auth.subject", tok.Subject)
+
+		return contextWithToken(ctx, tok), nil
+	}
+}
+
+// BasicAuth is a backwards-compatible Auth implementation: every token is
+// checked against a single shared secret, base64-encoded the same way the
+// original basicAuthFunc did. It has no concept of per-subject tokens, so
+// Generate always returns the base64-encoded shared secret, Verify only
+// compares t.ID against it and leaves t's other fields as they are, and
+// Revoke is unsupported.
+type BasicAuth struct {
+	token string
+}
+
+// NewBasicAuth returns an Auth backed by a single shared secret.
+func NewBasicAuth(token string) *BasicAuth {
+	return &BasicAuth{token: token}
+}
+
+func (b *BasicAuth) Generate(subject string) (*Token, error) {
+	return &Token{
+		ID:      base64.StdEncoding.EncodeToString([]byte(b.token)),
+		Subject: subject,
+		Created: time.Now(),
+	}, nil
+}
+
+func (b *BasicAuth) Verify(t *Token) error {
+	expected := base64.StdEncoding.EncodeToString([]byte(b.token))
+	if strings.Compare(t.ID, expected) != 0 {
+		return errors.New("tokens do not match")
+	}
+
+	return nil
+}
+
+func (b *BasicAuth) Revoke(t *Token) error {
+	return errors.New("basic auth does not support revoking its shared token")
+}
+
+// BearerAuth is an in-memory bearer-token store. Tests mint tokens with
+// Generate, present their ID to the client under the "bearer" scheme, and
+// can Revoke them mid-test to exercise expiry/revocation handling in the
+// client under test.
+type BearerAuth struct {
+	mu     sync.Mutex
+	tokens map[string]*Token
+	ttl    time.Duration
+}
+
+// BearerAuthOption configures a BearerAuth returned by NewBearerAuth.
+type BearerAuthOption func(*BearerAuth)
+
+// WithTokenTTL sets how long generated tokens remain valid. The zero value
+// (the default) means tokens never expire on their own.
+func WithTokenTTL(ttl time.Duration) BearerAuthOption {
+	return func(b *BearerAuth) {
+		b.ttl = ttl
+	}
+}
+
+// NewBearerAuth returns an Auth that mints random bearer tokens and tracks
+// them in memory until they expire or are revoked.
+func NewBearerAuth(opts ...BearerAuthOption) *BearerAuth {
+	b := &BearerAuth{tokens: map[string]*Token{}}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+func (b *BearerAuth) Generate(subject string) (*Token, error) {
+	return b.GenerateWithRoles(subject)
+}
+
+// GenerateWithRoles mints a token for subject carrying the given roles, for
+// use by policy hooks that check Token.Roles.
+func (b *BearerAuth) GenerateWithRoles(subject string, roles ...string) (*Token, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := &Token{
+		ID:       id.String(),
+		Subject:  subject,
+		Created:  time.Now(),
+		Roles:    roles,
+		Metadata: map[string]string{},
+	}
+
+	if b.ttl > 0 {
+		tok.Expiry = tok.Created.Add(b.ttl)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens[tok.ID] = tok
+
+	return tok, nil
+}
+
+func (b *BearerAuth) Verify(t *Token) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stored, ok := b.tokens[t.ID]
+	if !ok {
+		return errors.New("unknown or revoked token")
+	}
+
+	if stored.Expired() {
+		return errors.New("token expired")
+	}
+
+	*t = *stored
+
+	return nil
+}
+
+func (b *BearerAuth) Revoke(t *Token) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.tokens[t.ID]; !ok {
+		return errors.New("unknown token")
+	}
+
+	delete(b.tokens, t.ID)
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package safety
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestBasicAuthGenerateVerifyRoundTrip(t *testing.T) {
+	a := NewBasicAuth("shh")
+
+	tok, err := a.Generate("client-1")
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if err := a.Verify(tok); err != nil {
+		t.Fatalf("Verify() error = %v, want nil", err)
+	}
+}
+
+func TestBasicAuthVerifyRejectsWrongToken(t *testing.T) {
+	a := NewBasicAuth("shh")
+
+	if err := a.Verify(&Token{ID: "not the right token"}); err == nil {
+		t.Fatal("Verify() succeeded for a token that does not match the shared secret")
+	}
+}
+
+func TestBasicAuthRevokeUnsupported(t *testing.T) {
+	a := NewBasicAuth("shh")
+
+	tok, _ := a.Generate("client-1")
+
+	if err := a.Revoke(tok); err == nil {
+		t.Fatal("Revoke() succeeded, want an error: BasicAuth has no per-token state to revoke")
+	}
+}
+
+func TestBearerAuthGenerateVerifyRoundTrip(t *testing.T) {
+	a := NewBearerAuth()
+
+	tok, err := a.Generate("client-1")
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	verified := &Token{ID: tok.ID}
+	if err := a.Verify(verified); err != nil {
+		t.Fatalf("Verify() error = %v, want nil", err)
+	}
+
+	if verified.Subject != "client-1" {
+		t.Fatalf("Verify() filled Subject = %q, want client-1", verified.Subject)
+	}
+}
+
+func TestBearerAuthVerifyRejectsUnknownToken(t *testing.T) {
+	a := NewBearerAuth()
+
+	if err := a.Verify(&Token{ID: "never issued"}); err == nil {
+		t.Fatal("Verify() succeeded for a token that was never generated")
+	}
+}
+
+func TestBearerAuthRevokeLocksOutFutureVerify(t *testing.T) {
+	a := NewBearerAuth()
+
+	tok, _ := a.Generate("client-1")
+
+	if err := a.Revoke(tok); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+
+	if err := a.Verify(&Token{ID: tok.ID}); err == nil {
+		t.Fatal("Verify() succeeded for a revoked token")
+	}
+}
+
+func TestBearerAuthTTLExpiry(t *testing.T) {
+	a := NewBearerAuth(WithTokenTTL(10 * time.Millisecond))
+
+	tok, err := a.Generate("client-1")
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if err := a.Verify(&Token{ID: tok.ID}); err != nil {
+		t.Fatalf("Verify() error = %v before expiry, want nil", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if err := a.Verify(&Token{ID: tok.ID}); err == nil {
+		t.Fatal("Verify() succeeded for a token past its TTL")
+	}
+}
+
+func contextWithBearerToken(scheme, id string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", scheme+" "+id))
+}
+
+func TestAuthFuncVerifiesAndAttachesToken(t *testing.T) {
+	s := New(WithAuth(NewBearerAuth(), "bearer"))
+
+	tok, err := s.Auth().Generate("client-1")
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	ctx, err := s.authFunc()(contextWithBearerToken("bearer", tok.ID))
+	if err != nil {
+		t.Fatalf("authFunc() error = %v, want nil", err)
+	}
+
+	got, ok := TokenFromContext(ctx)
+	if !ok || got.Subject != "client-1" {
+		t.Fatalf("TokenFromContext() = %+v, %v, want client-1 token", got, ok)
+	}
+}
+
+func TestAuthFuncRejectsInvalidToken(t *testing.T) {
+	s := New(WithAuth(NewBearerAuth(), "bearer"))
+
+	_, err := s.authFunc()(contextWithBearerToken("bearer", "bogus"))
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("got code %v, want Unauthenticated", status.Code(err))
+	}
+}
+
+func TestAuthFuncWithAuthPolicyDeniesOnRole(t *testing.T) {
+	auth := NewBearerAuth()
+
+	policy := func(ctx context.Context, method string, tok *Token) error {
+		if !tok.HasRole("admin") {
+			return errors.New("insufficient role")
+		}
+
+		return nil
+	}
+
+	s := New(WithAuth(auth, "bearer"), WithAuthPolicy(policy))
+
+	memberTok, err := auth.GenerateWithRoles("client-1", "member")
+	if err != nil {
+		t.Fatalf("GenerateWithRoles() error = %v", err)
+	}
+
+	if _, err := s.authFunc()(contextWithBearerToken("bearer", memberTok.ID)); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("got code %v, want PermissionDenied for a member-only token", status.Code(err))
+	}
+
+	adminTok, err := auth.GenerateWithRoles("client-2", "admin")
+	if err != nil {
+		t.Fatalf("GenerateWithRoles() error = %v", err)
+	}
+
+	if _, err := s.authFunc()(contextWithBearerToken("bearer", adminTok.ID)); err != nil {
+		t.Fatalf("authFunc() error = %v for an admin token, want nil", err)
+	}
+}
@@ -0,0 +1,225 @@
+package safety
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	mvmv1 "github.com/weaveworks-liquidmetal/flintlock/api/services/microvm/v1alpha1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Latency describes an injected delay: a fixed duration, or a duration
+// drawn from an exponential distribution with the given mean. At most one
+// of the two should be set; Fixed takes precedence if both are.
+type Latency struct {
+	Fixed       time.Duration
+	Exponential time.Duration
+}
+
+// FaultSpec describes a fault rule applied to matching RPCs: an injected
+// delay, a forced error code, a request-size limit, or an abrupt stream
+// abort.
+type FaultSpec struct {
+	Latency Latency
+
+	// Code, if non-zero (not codes.OK), is returned instead of handling
+	// the call.
+	Code    codes.Code
+	Message string
+
+	// MaxRequestBytes, if non-zero, rejects unary calls whose request
+	// exceeds this size with codes.ResourceExhausted. Streaming calls are
+	// not checked against it.
+	MaxRequestBytes int
+
+	// AbortStream, for streaming RPCs, drops the connection with Code as
+	// soon as the handler tries to send its first message, simulating the
+	// server dying mid-stream rather than rejecting the call up front.
+	AbortStream bool
+}
+
+// WithFaultInjection enables the fault-injection interceptor layer,
+// seeded with seed so that any randomised behaviour (e.g. exponential
+// latency) is reproducible across CI runs. Configure rules at runtime via
+// srv.Faults().SetRule/SetUIDRule.
+func WithFaultInjection(seed int64) Option {
+	return func(s *FakeServer) {
+		s.faultsImpl = newFaults(seed)
+	}
+}
+
+// Faults is the runtime control surface for the fault-injection layer:
+// SetRule/SetUIDRule configure which RPCs misbehave and how.
+type Faults struct {
+	mu       sync.Mutex
+	rand     *rand.Rand
+	rules    map[string]FaultSpec
+	uidRules map[string]FaultSpec
+}
+
+func newFaults(seed int64) *Faults {
+	return &Faults{
+		rand:     rand.New(rand.NewSource(seed)),
+		rules:    map[string]FaultSpec{},
+		uidRules: map[string]FaultSpec{},
+	}
+}
+
+// Faults returns the fault-injection control surface configured via
+// WithFaultInjection, or nil if the server was not configured with one.
+func (s *FakeServer) Faults() *Faults {
+	return s.faultsImpl
+}
+
+// SetRule configures the fault rule applied to every call to method (its
+// full gRPC method name, e.g.
+// "/microvm.services.api.v1alpha1.MicroVM/GetMicroVM"). Passing the zero
+// FaultSpec clears any existing rule for method.
+func (f *Faults) SetRule(method string, spec FaultSpec) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if spec == (FaultSpec{}) {
+		delete(f.rules, method)
+		return
+	}
+
+	f.rules[method] = spec
+}
+
+// SetUIDRule configures a fault rule applied to any unary call whose
+// request carries the given microvm uid (GetMicroVM, DeleteMicroVM),
+// taking precedence over a method-wide rule. Passing the zero FaultSpec
+// clears any existing rule for uid.
+func (f *Faults) SetUIDRule(uid string, spec FaultSpec) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if spec == (FaultSpec{}) {
+		delete(f.uidRules, uid)
+		return
+	}
+
+	f.uidRules[uid] = spec
+}
+
+func (f *Faults) ruleFor(method, uid string) (FaultSpec, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if uid != "" {
+		if spec, ok := f.uidRules[uid]; ok {
+			return spec, true
+		}
+	}
+
+	spec, ok := f.rules[method]
+
+	return spec, ok
+}
+
+func (f *Faults) sleep(l Latency) {
+	switch {
+	case l.Fixed > 0:
+		time.Sleep(l.Fixed)
+	case l.Exponential > 0:
+		f.mu.Lock()
+		d := time.Duration(f.rand.ExpFloat64() * float64(l.Exponential))
+		f.mu.Unlock()
+		time.Sleep(d)
+	}
+}
+
+func faultMessage(spec FaultSpec) string {
+	if spec.Message != "" {
+		return spec.Message
+	}
+
+	return "fault injected"
+}
+
+// uidFromRequest extracts the microvm uid a request targets, for the RPCs
+// that carry one, so SetUIDRule can match it.
+func uidFromRequest(req interface{}) string {
+	switch r := req.(type) {
+	case *mvmv1.GetMicroVMRequest:
+		return r.Uid
+	case *mvmv1.DeleteMicroVMRequest:
+		return r.Uid
+	default:
+		return ""
+	}
+}
+
+func (s *FakeServer) faultUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		spec, ok := s.faultsImpl.ruleFor(info.FullMethod, uidFromRequest(req))
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		s.faultsImpl.sleep(spec.Latency)
+
+		if spec.MaxRequestBytes > 0 {
+			if msg, ok := req.(proto.Message); ok {
+				if size := proto.Size(msg); size > spec.MaxRequestBytes {
+					return nil, status.Errorf(codes.ResourceExhausted,
+						"request size %d exceeds configured limit %d", size, spec.MaxRequestBytes)
+				}
+			}
+		}
+
+		if spec.Code != codes.OK {
+			return nil, status.Error(spec.Code, faultMessage(spec))
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// abortingServerStream fails every send with err, simulating a server
+// that died partway through handling a streaming RPC.
+type abortingServerStream struct {
+	grpc.ServerStream
+	err error
+}
+
+func (a *abortingServerStream) SendMsg(m interface{}) error {
+	return a.err
+}
+
+func (s *FakeServer) faultStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		spec, ok := s.faultsImpl.ruleFor(info.FullMethod, "")
+		if !ok {
+			return handler(srv, ss)
+		}
+
+		s.faultsImpl.sleep(spec.Latency)
+
+		if spec.AbortStream {
+			return handler(srv, &abortingServerStream{ServerStream: ss, err: status.Error(spec.Code, faultMessage(spec))})
+		}
+
+		if spec.Code != codes.OK {
+			return status.Error(spec.Code, faultMessage(spec))
+		}
+
+		return handler(srv, ss)
+	}
+}
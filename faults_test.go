@@ -0,0 +1,144 @@
+package safety
+
+import (
+	"context"
+	"testing"
+
+	mvmv1 "github.com/weaveworks-liquidmetal/flintlock/api/services/microvm/v1alpha1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFaultsUIDRuleTakesPrecedenceOverMethodRule(t *testing.T) {
+	f := newFaults(1)
+	f.SetRule("/m/Method", FaultSpec{Code: codes.Internal})
+	f.SetUIDRule("uid-1", FaultSpec{Code: codes.NotFound})
+
+	spec, ok := f.ruleFor("/m/Method", "uid-1")
+	if !ok || spec.Code != codes.NotFound {
+		t.Fatalf("ruleFor() = %+v, %v; want NotFound rule", spec, ok)
+	}
+
+	spec, ok = f.ruleFor("/m/Method", "uid-2")
+	if !ok || spec.Code != codes.Internal {
+		t.Fatalf("ruleFor() = %+v, %v; want method rule for an unmatched uid", spec, ok)
+	}
+}
+
+func TestFaultsSetRuleClearsOnZeroValue(t *testing.T) {
+	f := newFaults(1)
+	f.SetRule("/m/Method", FaultSpec{Code: codes.Internal})
+	f.SetRule("/m/Method", FaultSpec{})
+
+	if _, ok := f.ruleFor("/m/Method", ""); ok {
+		t.Fatal("ruleFor() found a rule after it was cleared with the zero FaultSpec")
+	}
+}
+
+func TestFaultUnaryInterceptorForcesConfiguredCode(t *testing.T) {
+	s := New(WithFaultInjection(1))
+	s.Faults().SetRule("/m/Method", FaultSpec{Code: codes.ResourceExhausted, Message: "nope"})
+
+	handlerCalled := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerCalled = true
+		return "ok", nil
+	}
+
+	_, err := s.faultUnaryInterceptor()(context.Background(), "req", &grpc.UnaryServerInfo{FullMethod: "/m/Method"}, handler)
+	if handlerCalled {
+		t.Fatal("handler was called despite a forced-error fault rule")
+	}
+
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got code %v, want ResourceExhausted", status.Code(err))
+	}
+}
+
+func TestFaultUnaryInterceptorPassesThroughWithoutRule(t *testing.T) {
+	s := New(WithFaultInjection(1))
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	resp, err := s.faultUnaryInterceptor()(context.Background(), "req", &grpc.UnaryServerInfo{FullMethod: "/m/Method"}, handler)
+	if err != nil || resp != "ok" {
+		t.Fatalf("got (%v, %v), want (\"ok\", nil)", resp, err)
+	}
+}
+
+func TestFaultUnaryInterceptorRejectsOversizedRequest(t *testing.T) {
+	s := New(WithFaultInjection(1))
+	s.Faults().SetRule("/m/Method", FaultSpec{MaxRequestBytes: 1})
+
+	handlerCalled := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerCalled = true
+		return "ok", nil
+	}
+
+	req := &mvmv1.GetMicroVMRequest{Uid: "a-much-longer-uid-than-one-byte"}
+
+	_, err := s.faultUnaryInterceptor()(context.Background(), req, &grpc.UnaryServerInfo{FullMethod: "/m/Method"}, handler)
+	if handlerCalled {
+		t.Fatal("handler was called despite the request exceeding MaxRequestBytes")
+	}
+
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("got code %v, want ResourceExhausted", status.Code(err))
+	}
+}
+
+func TestFaultStreamInterceptorAbortsOnSend(t *testing.T) {
+	s := New(WithFaultInjection(1))
+	s.Faults().SetRule("/m/Stream", FaultSpec{AbortStream: true, Code: codes.Unavailable, Message: "connection dropped"})
+
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		return ss.SendMsg("first message")
+	}
+
+	err := s.faultStreamInterceptor()(nil, &fakeServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/m/Stream"}, handler)
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("got code %v, want Unavailable", status.Code(err))
+	}
+}
+
+func TestFaultStreamInterceptorForcesConfiguredCodeUpFront(t *testing.T) {
+	s := New(WithFaultInjection(1))
+	s.Faults().SetRule("/m/Stream", FaultSpec{Code: codes.PermissionDenied})
+
+	handlerCalled := false
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		handlerCalled = true
+		return nil
+	}
+
+	err := s.faultStreamInterceptor()(nil, &fakeServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/m/Stream"}, handler)
+	if handlerCalled {
+		t.Fatal("handler was called despite a forced-error fault rule")
+	}
+
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("got code %v, want PermissionDenied", status.Code(err))
+	}
+}
+
+func TestFaultStreamInterceptorPassesThroughWithoutRule(t *testing.T) {
+	s := New(WithFaultInjection(1))
+
+	handlerCalled := false
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		handlerCalled = true
+		return nil
+	}
+
+	if err := s.faultStreamInterceptor()(nil, &fakeServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: "/m/Stream"}, handler); err != nil {
+		t.Fatalf("faultStreamInterceptor() error = %v, want nil", err)
+	}
+
+	if !handlerCalled {
+		t.Fatal("handler was not called despite no configured fault rule")
+	}
+}
@@ -0,0 +1,231 @@
+package safety
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/weaveworks-liquidmetal/flintlock/api/types"
+)
+
+// Transition describes how a microvm's status advances out of a given
+// state: after Delay has elapsed it moves to Next, or to FAILED instead
+// with probability FailureRate (0-1).
+type Transition struct {
+	Next        types.MicroVMStatus_MicroVMState
+	Delay       time.Duration
+	FailureRate float64
+}
+
+// Lifecycle models how a created microvm's status advances over time, and
+// how long a pending delete takes before the entry is actually removed.
+// Configure one with WithLifecycle so that GetMicroVM/ListMicroVMs, and
+// clients that poll for readiness, see realistic state transitions
+// instead of an immediate, permanent CREATED.
+type Lifecycle struct {
+	transitions map[types.MicroVMStatus_MicroVMState]Transition
+	deleteDelay time.Duration
+}
+
+// NewLifecycle returns a Lifecycle with no transitions configured; build
+// one up with WithTransition and WithDeleteDelay.
+func NewLifecycle() *Lifecycle {
+	return &Lifecycle{transitions: map[types.MicroVMStatus_MicroVMState]Transition{}}
+}
+
+// DefaultLifecycle is the Lifecycle used when FakeServer is not configured
+// with WithLifecycle: microvms move straight to CREATED and deletes take
+// effect immediately, matching the fake server's original behaviour. The
+// PENDING->CREATED transition has a zero Delay, so scheduleTransition
+// applies it synchronously and CreateMicroVM's response already reflects
+// it.
+func DefaultLifecycle() *Lifecycle {
+	return NewLifecycle().WithTransition(types.MicroVMStatus_PENDING, Transition{Next: types.MicroVMStatus_CREATED})
+}
+
+// WithTransition configures what happens when a microvm is in state from:
+// after t.Delay it moves to t.Next, or to FAILED with probability
+// t.FailureRate.
+func (l *Lifecycle) WithTransition(from types.MicroVMStatus_MicroVMState, t Transition) *Lifecycle {
+	l.transitions[from] = t
+	return l
+}
+
+// WithDeleteDelay configures how long DeleteMicroVM takes to move a
+// microvm to DELETING and then actually remove it. The zero value (the
+// default) removes it immediately.
+func (l *Lifecycle) WithDeleteDelay(d time.Duration) *Lifecycle {
+	l.deleteDelay = d
+	return l
+}
+
+// WithLifecycle configures srv to advance created microvms through lc
+// instead of the default immediate-CREATED behaviour.
+func WithLifecycle(lc *Lifecycle) Option {
+	return func(s *FakeServer) {
+		s.lifecycleImpl = lc
+	}
+}
+
+// microvmState tracks the live lifecycle state of one saved microvm.
+// generation is bumped whenever the state is set directly (by Advance) so
+// that an in-flight scheduled transition which raced with it can notice
+// it's stale and give up instead of clobbering the new state.
+type microvmState struct {
+	mu         sync.Mutex
+	state      types.MicroVMStatus_MicroVMState
+	generation uint64
+}
+
+func (s *FakeServer) lifecycle() *Lifecycle {
+	if s.lifecycleImpl != nil {
+		return s.lifecycleImpl
+	}
+
+	return DefaultLifecycle()
+}
+
+// trackState starts tracking uid at PENDING and, if the lifecycle
+// configures a transition out of it, schedules that transition in the
+// background.
+func (s *FakeServer) trackState(uid string) {
+	st := &microvmState{state: types.MicroVMStatus_PENDING}
+
+	s.statesMu.Lock()
+	if s.states == nil {
+		s.states = map[string]*microvmState{}
+	}
+	s.states[uid] = st
+	s.statesMu.Unlock()
+
+	s.scheduleTransition(uid, st, types.MicroVMStatus_PENDING, st.generation)
+}
+
+// scheduleTransition arranges for st to move out of from after whatever
+// delay the lifecycle configures for it, then recurses into whatever
+// comes after that, so a microvm can walk a multi-step graph unattended.
+// A zero-Delay transition is applied synchronously, in the caller's
+// goroutine, instead of being handed to a background timer: CreateMicroVM,
+// and callers of Advance, read st's state right after scheduling, and
+// DefaultLifecycle's PENDING->CREATED transition has a zero Delay, so
+// those callers need the transition to have already happened by the time
+// scheduleTransition returns.
+func (s *FakeServer) scheduleTransition(uid string, st *microvmState, from types.MicroVMStatus_MicroVMState, generation uint64) {
+	t, ok := s.lifecycle().transitions[from]
+	if !ok {
+		return
+	}
+
+	if t.Delay <= 0 {
+		s.applyTransition(uid, st, t, generation)
+		return
+	}
+
+	go func() {
+		time.Sleep(t.Delay)
+		s.applyTransition(uid, st, t, generation)
+	}()
+}
+
+// applyTransition moves st out of the state generation was read under,
+// into t.Next or FAILED per t.FailureRate, then recurses via
+// scheduleTransition so a microvm can walk a multi-step graph unattended.
+// It gives up without applying anything if st has since moved on
+// (generation mismatch), which happens when Advance or a later
+// transition raced it.
+func (s *FakeServer) applyTransition(uid string, st *microvmState, t Transition, generation uint64) {
+	st.mu.Lock()
+	if st.generation != generation {
+		st.mu.Unlock()
+		return
+	}
+
+	next := t.Next
+	if t.FailureRate > 0 && rand.Float64() < t.FailureRate {
+		next = types.MicroVMStatus_FAILED
+	}
+
+	st.state = next
+	st.generation++
+	newGeneration := st.generation
+	st.mu.Unlock()
+
+	s.publishState(uid, next)
+	s.scheduleTransition(uid, st, next, newGeneration)
+}
+
+// stateFor returns the live lifecycle state for uid, defaulting to CREATED
+// for specs that were added via Load rather than CreateMicroVM and so
+// never had a lifecycle started for them.
+func (s *FakeServer) stateFor(uid string) types.MicroVMStatus_MicroVMState {
+	s.statesMu.Lock()
+	st, ok := s.states[uid]
+	s.statesMu.Unlock()
+
+	if !ok {
+		return types.MicroVMStatus_CREATED
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.state
+}
+
+// stateForEvent returns the status to report for a Store Event. A delete
+// always reports DELETING regardless of lifecycle tracking state: the
+// goroutine dequeuing ev races removeSpec's untrackState call, and
+// falling back to stateFor here would let that race flip what a
+// ListMicroVMsStream subscriber sees for a microvm that no longer exists
+// between DELETING (still tracked) and CREATED (stateFor's untracked
+// fallback, meant for Load-ed specs, not deleted ones).
+func (s *FakeServer) stateForEvent(ev Event) types.MicroVMStatus_MicroVMState {
+	if ev.Type == EventDelete {
+		return types.MicroVMStatus_DELETING
+	}
+
+	return s.stateFor(*ev.Spec.Uid)
+}
+
+// stateOrCreate returns the tracked microvmState for uid, creating one at
+// the zero state if it isn't already tracked.
+func (s *FakeServer) stateOrCreate(uid string) *microvmState {
+	s.statesMu.Lock()
+	defer s.statesMu.Unlock()
+
+	if s.states == nil {
+		s.states = map[string]*microvmState{}
+	}
+
+	st, ok := s.states[uid]
+	if !ok {
+		st = &microvmState{}
+		s.states[uid] = st
+	}
+
+	return st
+}
+
+// Advance deterministically sets uid's lifecycle state, cancelling any
+// transition that was still scheduled for it, then schedules whatever
+// transition the lifecycle configures out of the new state. This lets
+// tests drive a microvm through its lifecycle without waiting on
+// wall-clock delays.
+func (s *FakeServer) Advance(uid string, state types.MicroVMStatus_MicroVMState) {
+	st := s.stateOrCreate(uid)
+
+	st.mu.Lock()
+	st.state = state
+	st.generation++
+	generation := st.generation
+	st.mu.Unlock()
+
+	s.publishState(uid, state)
+	s.scheduleTransition(uid, st, state, generation)
+}
+
+func (s *FakeServer) untrackState(uid string) {
+	s.statesMu.Lock()
+	delete(s.states, uid)
+	s.statesMu.Unlock()
+}
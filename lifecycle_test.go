@@ -0,0 +1,85 @@
+package safety
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mvmv1 "github.com/weaveworks-liquidmetal/flintlock/api/services/microvm/v1alpha1"
+	"github.com/weaveworks-liquidmetal/flintlock/api/types"
+)
+
+func TestCreateMicroVM_DefaultLifecycleIsCreatedImmediately(t *testing.T) {
+	s := New()
+
+	resp, err := s.CreateMicroVM(context.Background(), &mvmv1.CreateMicroVMRequest{
+		Microvm: &types.MicroVMSpec{Id: "vm-1", Namespace: "ns1"},
+	})
+	if err != nil {
+		t.Fatalf("CreateMicroVM() error = %v", err)
+	}
+
+	got, err := s.GetMicroVM(context.Background(), &mvmv1.GetMicroVMRequest{Uid: *resp.Microvm.Spec.Uid})
+	if err != nil {
+		t.Fatalf("GetMicroVM() error = %v", err)
+	}
+
+	if got.Microvm.Status.State != types.MicroVMStatus_CREATED {
+		t.Fatalf("GetMicroVM() state = %v, want CREATED", got.Microvm.Status.State)
+	}
+}
+
+func TestLifecycle_FailureRateMovesToFailed(t *testing.T) {
+	lc := NewLifecycle().WithTransition(types.MicroVMStatus_PENDING, Transition{
+		Next:        types.MicroVMStatus_CREATED,
+		FailureRate: 1,
+	})
+	s := New(WithLifecycle(lc))
+
+	resp, err := s.CreateMicroVM(context.Background(), &mvmv1.CreateMicroVMRequest{
+		Microvm: &types.MicroVMSpec{Id: "vm-1", Namespace: "ns1"},
+	})
+	if err != nil {
+		t.Fatalf("CreateMicroVM() error = %v", err)
+	}
+
+	got, err := s.GetMicroVM(context.Background(), &mvmv1.GetMicroVMRequest{Uid: *resp.Microvm.Spec.Uid})
+	if err != nil {
+		t.Fatalf("GetMicroVM() error = %v", err)
+	}
+
+	if got.Microvm.Status.State != types.MicroVMStatus_FAILED {
+		t.Fatalf("GetMicroVM() state = %v, want FAILED (FailureRate 1)", got.Microvm.Status.State)
+	}
+}
+
+func TestDeleteMicroVM_WithDeleteDelayKeepsSpecUntilItElapses(t *testing.T) {
+	s := New(WithLifecycle(NewLifecycle().WithDeleteDelay(50 * time.Millisecond)))
+
+	resp, err := s.CreateMicroVM(context.Background(), &mvmv1.CreateMicroVMRequest{
+		Microvm: &types.MicroVMSpec{Id: "vm-1", Namespace: "ns1"},
+	})
+	if err != nil {
+		t.Fatalf("CreateMicroVM() error = %v", err)
+	}
+	uid := *resp.Microvm.Spec.Uid
+
+	if _, err := s.DeleteMicroVM(context.Background(), &mvmv1.DeleteMicroVMRequest{Uid: uid}); err != nil {
+		t.Fatalf("DeleteMicroVM() error = %v", err)
+	}
+
+	got, err := s.GetMicroVM(context.Background(), &mvmv1.GetMicroVMRequest{Uid: uid})
+	if err != nil {
+		t.Fatalf("GetMicroVM() error = %v, want the spec to still exist mid-delay", err)
+	}
+
+	if got.Microvm.Status.State != types.MicroVMStatus_DELETING {
+		t.Fatalf("GetMicroVM() state = %v, want DELETING", got.Microvm.Status.State)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := s.GetMicroVM(context.Background(), &mvmv1.GetMicroVMRequest{Uid: uid}); err == nil {
+		t.Fatal("GetMicroVM() succeeded after the delete delay elapsed, want not-found")
+	}
+}
@@ -0,0 +1,152 @@
+package safety
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// LogEntry is one structured record of a completed RPC.
+type LogEntry struct {
+	Method   string
+	Peer     string
+	Subject  string
+	Duration time.Duration
+	Code     codes.Code
+}
+
+// Logger receives a LogEntry for every RPC the logging interceptor
+// observes. Implementations can forward entries to zap, logr, slog, or
+// anywhere else structured logging needs to go; wrap whichever logger you
+// use in a small adapter that satisfies this interface.
+type Logger interface {
+	Log(entry LogEntry)
+}
+
+// MemoryLogger is the Logger FakeServer uses by default: it keeps every
+// entry it sees in memory for tests to assert against via
+// FakeServer.Logs().
+type MemoryLogger struct {
+	mu      sync.Mutex
+	entries []LogEntry
+}
+
+// NewMemoryLogger returns an empty MemoryLogger.
+func NewMemoryLogger() *MemoryLogger {
+	return &MemoryLogger{}
+}
+
+func (m *MemoryLogger) Log(entry LogEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries = append(m.entries, entry)
+}
+
+// Entries returns a copy of every LogEntry captured so far.
+func (m *MemoryLogger) Entries() []LogEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]LogEntry, len(m.entries))
+	copy(out, m.entries)
+
+	return out
+}
+
+// WithLogger configures srv to send its per-RPC LogEntry records to
+// logger instead of the default MemoryLogger.
+func WithLogger(logger Logger) Option {
+	return func(s *FakeServer) {
+		s.loggerImpl = logger
+	}
+}
+
+// WithRecoveryHandler customises how a panic inside an RPC handler is
+// converted into the error returned to the client. The default converts
+// any panic into a codes.Internal error.
+func WithRecoveryHandler(fn RecoveryHandlerFunc) Option {
+	return func(s *FakeServer) {
+		s.recoveryHandler = fn
+	}
+}
+
+// RecoveryHandlerFunc recovers from the panic p by returning the error to
+// send to the client instead.
+type RecoveryHandlerFunc func(p interface{}) error
+
+func (s *FakeServer) logger() Logger {
+	s.loggerOnce.Do(func() {
+		if s.loggerImpl == nil {
+			s.loggerImpl = NewMemoryLogger()
+		}
+	})
+
+	return s.loggerImpl
+}
+
+// Logs returns the RPC log entries captured so far, if the configured
+// Logger is the default MemoryLogger. It returns nil if WithLogger was
+// used to wire in a different Logger implementation.
+func (s *FakeServer) Logs() []LogEntry {
+	if ml, ok := s.logger().(*MemoryLogger); ok {
+		return ml.Entries()
+	}
+
+	return nil
+}
+
+func (s *FakeServer) logRPC(ctx context.Context, method string, start time.Time, err error) {
+	var peerAddr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		peerAddr = p.Addr.String()
+	}
+
+	var subject string
+	if tok, ok := TokenFromContext(ctx); ok {
+		subject = tok.Subject
+	}
+
+	s.logger().Log(LogEntry{
+		Method:   method,
+		Peer:     peerAddr,
+		Subject:  subject,
+		Duration: time.Since(start),
+		Code:     status.Code(err),
+	})
+}
+
+func (s *FakeServer) loggingUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		s.logRPC(ctx, info.FullMethod, start, err)
+
+		return resp, err
+	}
+}
+
+func (s *FakeServer) loggingStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		s.logRPC(ss.Context(), info.FullMethod, start, err)
+
+		return err
+	}
+}
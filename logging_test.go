@@ -0,0 +1,111 @@
+package safety
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeServerStream implements grpc.ServerStream over a context, for tests
+// that drive a stream interceptor without a real gRPC connection.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (f *fakeServerStream) Context() context.Context { return f.ctx }
+
+func TestLoggingUnaryInterceptorCapturesEntry(t *testing.T) {
+	s := New()
+
+	ctx := contextWithToken(context.Background(), &Token{Subject: "client-1"})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		time.Sleep(time.Millisecond)
+		return "ok", nil
+	}
+
+	_, err := s.loggingUnaryInterceptor()(ctx, "req", &grpc.UnaryServerInfo{FullMethod: "/m/Method"}, handler)
+	if err != nil {
+		t.Fatalf("loggingUnaryInterceptor() error = %v", err)
+	}
+
+	entries := s.Logs()
+	if len(entries) != 1 {
+		t.Fatalf("Logs() = %d entries, want 1", len(entries))
+	}
+
+	got := entries[0]
+	if got.Method != "/m/Method" || got.Subject != "client-1" || got.Code != codes.OK || got.Duration <= 0 {
+		t.Fatalf("Logs()[0] = %+v, want Method=/m/Method Subject=client-1 Code=OK Duration>0", got)
+	}
+}
+
+func TestLoggingStreamInterceptorCapturesErrorCode(t *testing.T) {
+	s := New()
+
+	ss := &fakeServerStream{ctx: context.Background()}
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		return status.Error(codes.Unavailable, "down")
+	}
+
+	err := s.loggingStreamInterceptor()(nil, ss, &grpc.StreamServerInfo{FullMethod: "/m/Stream"}, handler)
+	if status.Code(err) != codes.Unavailable {
+		t.Fatalf("loggingStreamInterceptor() error code = %v, want Unavailable", status.Code(err))
+	}
+
+	entries := s.Logs()
+	if len(entries) != 1 || entries[0].Code != codes.Unavailable {
+		t.Fatalf("Logs() = %+v, want one entry with Code=Unavailable", entries)
+	}
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Log(LogEntry) {}
+
+func TestLogsReturnsNilForNonMemoryLogger(t *testing.T) {
+	s := New(WithLogger(discardLogger{}))
+
+	if logs := s.Logs(); logs != nil {
+		t.Fatalf("Logs() = %v, want nil for a non-MemoryLogger", logs)
+	}
+}
+
+func TestRecoveryConvertsDefaultPanicToInternal(t *testing.T) {
+	s := New()
+
+	recovered := grpc_recovery.UnaryServerInterceptor(s.recoveryOpts()...)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	}
+
+	_, err := recovered(context.Background(), "req", &grpc.UnaryServerInfo{FullMethod: "/m/Method"}, handler)
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("got code %v, want Internal", status.Code(err))
+	}
+}
+
+func TestRecoveryUsesConfiguredHandler(t *testing.T) {
+	wantErr := status.Error(codes.Unknown, "custom recovery")
+	s := New(WithRecoveryHandler(func(p interface{}) error {
+		return wantErr
+	}))
+
+	recovered := grpc_recovery.UnaryServerInterceptor(s.recoveryOpts()...)
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic(errors.New("boom"))
+	}
+
+	_, err := recovered(context.Background(), "req", &grpc.UnaryServerInfo{FullMethod: "/m/Method"}, handler)
+	if err != wantErr {
+		t.Fatalf("got error %v, want the configured recovery handler's error", err)
+	}
+}
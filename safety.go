@@ -2,21 +2,23 @@ package safety
 
 import (
 	"context"
-	"encoding/base64"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
-	"strings"
+	"sync"
+	"time"
 
 	grpc_mw "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
+	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	uuid "github.com/nu7hatch/gouuid"
 	mvmv1 "github.com/weaveworks-liquidmetal/flintlock/api/services/microvm/v1alpha1"
 	"github.com/weaveworks-liquidmetal/flintlock/api/types"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/test/bufconn"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"k8s.io/utils/pointer"
@@ -25,13 +27,81 @@ import (
 // Cleanup implements a function to tear down any fake server resources.
 type Cleanup func() error
 
-func New() *FakeServer {
-	return &FakeServer{}
+// Option configures a FakeServer constructed with New.
+type Option func(*FakeServer)
+
+// WithAuth configures srv to authenticate RPCs against auth, extracting
+// the presented token from incoming metadata under scheme (e.g. "basic",
+// "bearer"). Use srv.Auth() to reach the configured Auth at test time, for
+// example to mint or revoke tokens.
+func WithAuth(auth Auth, scheme string) Option {
+	return func(s *FakeServer) {
+		s.authImpl = auth
+		s.authScheme = scheme
+	}
+}
+
+// WithAuthPolicy registers a hook that runs after a token has been
+// verified for an RPC, letting tests deny calls based on the resolved
+// token's roles or the method being invoked.
+func WithAuthPolicy(policy PolicyFunc) Option {
+	return func(s *FakeServer) {
+		s.authPolicy = policy
+	}
+}
+
+// WithTLSConfig configures srv to terminate TLS using cfg, which is wired
+// into the underlying grpc.Server as its transport credentials. Set
+// cfg.ClientCAs and cfg.ClientAuth (e.g. to tls.RequireAndVerifyClientCert)
+// to require client certificates for mTLS. See NewServerTLSConfig and
+// GenerateSelfSignedCert for ways to build cfg.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(s *FakeServer) {
+		s.tlsConfig = cfg
+	}
+}
+
+func New(opts ...Option) *FakeServer {
+	s := &FakeServer{}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
 
 type FakeServer struct {
-	savedSpecs []*types.MicroVMSpec
-	cleanup    Cleanup
+	storeOnce sync.Once
+	store     Store
+	cleanup   Cleanup
+
+	authImpl   Auth
+	authScheme string
+	authPolicy PolicyFunc
+
+	tlsConfig *tls.Config
+
+	lifecycleImpl *Lifecycle
+	statesMu      sync.Mutex
+	states        map[string]*microvmState
+
+	brokerOnce       sync.Once
+	streamBroker     *broker[*types.MicroVM]
+	streamBufferSize int
+	streamKeepalive  time.Duration
+
+	faultsImpl *Faults
+
+	loggerOnce      sync.Once
+	loggerImpl      Logger
+	recoveryHandler RecoveryHandlerFunc
+}
+
+// Auth returns the Auth implementation configured via WithAuth, or the
+// BasicAuth that Start/StartBuf set up from a non-empty token string. It
+// returns nil if neither happened.
+func (s *FakeServer) Auth() Auth {
+	return s.authImpl
 }
 
 // Start creates a new real server to respond to gRPC requests from the client.
@@ -46,7 +116,8 @@ func (s *FakeServer) Start(token string) string {
 		fmt.Println("Failed to start fake listener", err)
 	}
 
-	s.start(l, WithOpts(token))
+	s.ensureBasicAuth(token)
+	s.start(l, s.serverOptions())
 
 	return l.Addr().String()
 }
@@ -63,11 +134,25 @@ const bufSize = 1024 * 1024
 func (s *FakeServer) StartBuf(token string) (net.Conn, error) {
 	l := bufconn.Listen(bufSize)
 
-	s.start(l, WithOpts(token))
+	s.ensureBasicAuth(token)
+	s.start(l, s.serverOptions())
 
 	return l.Dial()
 }
 
+// ensureBasicAuth wires up the legacy shared-secret auth path for callers
+// that still pass a token string to Start/StartBuf instead of configuring
+// an Auth via WithAuth. It is a no-op if an Auth has already been
+// configured or no token was given.
+func (s *FakeServer) ensureBasicAuth(token string) {
+	if token == "" || s.authImpl != nil {
+		return
+	}
+
+	s.authImpl = NewBasicAuth(token)
+	s.authScheme = "basic"
+}
+
 func (s *FakeServer) start(l net.Listener, opts []grpc.ServerOption) {
 	grpcServer := grpc.NewServer(opts...)
 	mvmv1.RegisterMicroVMServer(grpcServer, s)
@@ -98,8 +183,20 @@ func (s *FakeServer) Stop() error {
 }
 
 // Load will overwrite the microvm array. Useful for testing batches.
+// Loaded microvms are not put through the configured Lifecycle: they read
+// back as CREATED immediately, as if they already existed before the fake
+// server started.
 func (s *FakeServer) Load(microvms ...*types.MicroVMSpec) {
-	s.savedSpecs = microvms
+	store := s.storeImpl()
+
+	existing, _ := store.List("", "")
+	for _, spec := range existing {
+		_ = store.Delete(*spec.Uid)
+	}
+
+	for _, spec := range microvms {
+		_ = store.Put(spec)
+	}
 }
 
 func (s *FakeServer) CreateMicroVM(
@@ -115,55 +212,61 @@ func (s *FakeServer) CreateMicroVM(
 
 	spec.Uid = pointer.String(uid.String())
 
-	s.savedSpecs = append(s.savedSpecs, spec)
+	s.trackState(*spec.Uid)
 
-	fmt.Println("microvm created: " + spec.Id)
+	if err := s.storeImpl().Put(spec); err != nil {
+		return nil, err
+	}
 
 	return &mvmv1.CreateMicroVMResponse{
 		Microvm: &types.MicroVM{
 			Version: 0,
 			Spec:    spec,
-			Status:  &types.MicroVMStatus{},
+			Status:  &types.MicroVMStatus{State: s.stateFor(*spec.Uid)},
 		},
 	}, nil
 }
 
 func (s *FakeServer) DeleteMicroVM(ctx context.Context, req *mvmv1.DeleteMicroVMRequest) (*emptypb.Empty, error) {
-	if len(s.savedSpecs) > 0 {
-		for i, spec := range s.savedSpecs {
-			if *spec.Uid == req.Uid {
-				s.savedSpecs[i] = s.savedSpecs[len(s.savedSpecs)-1]
-				fmt.Println("microvm deleted: " + spec.Id)
-			}
-		}
+	if _, err := s.storeImpl().Get(req.Uid); err != nil {
+		return &emptypb.Empty{}, nil
+	}
+
+	s.Advance(req.Uid, types.MicroVMStatus_DELETING)
 
-		s.savedSpecs = s.savedSpecs[:len(s.savedSpecs)-1]
+	delay := s.lifecycle().deleteDelay
+	if delay <= 0 {
+		s.removeSpec(req.Uid)
+		return &emptypb.Empty{}, nil
 	}
 
+	go func() {
+		time.Sleep(delay)
+		s.removeSpec(req.Uid)
+	}()
+
 	return &emptypb.Empty{}, nil
 }
 
-func (s *FakeServer) GetMicroVM(ctx context.Context, req *mvmv1.GetMicroVMRequest) (*mvmv1.GetMicroVMResponse, error) {
-	var requestSpec *types.MicroVMSpec
-
-	for _, spec := range s.savedSpecs {
-		if *spec.Uid == req.Uid {
-			requestSpec = spec
-		}
-	}
+// removeSpec drops uid's spec from the Store and stops tracking its
+// lifecycle state.
+func (s *FakeServer) removeSpec(uid string) {
+	_ = s.storeImpl().Delete(uid)
+	s.untrackState(uid)
+}
 
-	if requestSpec == nil {
+func (s *FakeServer) GetMicroVM(ctx context.Context, req *mvmv1.GetMicroVMRequest) (*mvmv1.GetMicroVMResponse, error) {
+	requestSpec, err := s.storeImpl().Get(req.Uid)
+	if err != nil {
 		return nil, errors.New("rpc error: microvm not found")
 	}
 
-	fmt.Println("microvm found: " + requestSpec.Id)
-
 	return &mvmv1.GetMicroVMResponse{
 		Microvm: &types.MicroVM{
 			Version: 0,
 			Spec:    requestSpec,
 			Status: &types.MicroVMStatus{
-				State: types.MicroVMStatus_CREATED,
+				State: s.stateFor(req.Uid),
 			},
 		},
 	}, nil
@@ -175,17 +278,20 @@ func (s *FakeServer) ListMicroVMs(
 ) (*mvmv1.ListMicroVMsResponse, error) {
 	microvms := []*types.MicroVM{}
 
-	for _, spec := range s.savedSpecs {
-		if shouldReturn(spec, req.Name, req.Namespace) {
-			m := &types.MicroVM{
-				Version: 0,
-				Spec:    spec,
-				Status: &types.MicroVMStatus{
-					State: types.MicroVMStatus_CREATED,
-				},
-			}
-			microvms = append(microvms, m)
+	specs, err := s.storeImpl().List(req.Namespace, req.GetName())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, spec := range specs {
+		m := &types.MicroVM{
+			Version: 0,
+			Spec:    spec,
+			Status: &types.MicroVMStatus{
+				State: s.stateFor(*spec.Uid),
+			},
 		}
+		microvms = append(microvms, m)
 	}
 
 	return &mvmv1.ListMicroVMsResponse{
@@ -205,52 +311,74 @@ func shouldReturn(spec *types.MicroVMSpec, name *string, namespace string) bool
 	return namespace == ""
 }
 
-func (s *FakeServer) ListMicroVMsStream(
-	req *mvmv1.ListMicroVMsRequest,
-	streamServer mvmv1.MicroVM_ListMicroVMsStreamServer,
-) error {
-	return nil
+// WithOpts returns the gRPC server options used to authenticate with a
+// single shared basic-auth token, matching the fake server's original
+// behaviour.
+//
+// Deprecated: construct a FakeServer with New(WithAuth(...)) and start it
+// with Start/StartBuf instead; this package-level helper is kept only for
+// existing callers that build their own grpc.Server rather than going
+// through FakeServer.Start/StartBuf.
+func WithOpts(authToken string) []grpc.ServerOption {
+	s := New()
+	s.ensureBasicAuth(authToken)
+
+	return s.serverOptions()
 }
 
-func WithOpts(authToken string) []grpc.ServerOption {
-	if authToken != "" {
-		return []grpc.ServerOption{
-			grpc.StreamInterceptor(grpc_mw.ChainStreamServer(
-				grpc_auth.StreamServerInterceptor(basicAuthFunc(authToken)),
-			)),
-			grpc.UnaryInterceptor(grpc_mw.ChainUnaryServer(
-				grpc_auth.UnaryServerInterceptor(basicAuthFunc(authToken)),
-			)),
-		}
+// serverOptions builds the gRPC server options for s: its configured
+// transport credentials if any, and its unary/stream interceptor chain.
+//
+// The chain always runs, in order: fault-injection (if configured, so
+// tests can reproduce failures before a token is ever checked), ctxtags
+// (so downstream interceptors can tag the request), auth if configured
+// (else the original Prometheus instrumentation), structured logging, and
+// finally panic recovery closest to the handler so logging observes the
+// recovered status code rather than a crashed goroutine.
+func (s *FakeServer) serverOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+
+	if s.tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.tlsConfig)))
 	}
 
-	return []grpc.ServerOption{
-		grpc.StreamInterceptor(grpc_prometheus.StreamServerInterceptor),
-		grpc.UnaryInterceptor(grpc_prometheus.UnaryServerInterceptor),
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+
+	if s.faultsImpl != nil {
+		unary = append(unary, s.faultUnaryInterceptor())
+		stream = append(stream, s.faultStreamInterceptor())
 	}
-}
 
-func basicAuthFunc(setServerToken string) grpc_auth.AuthFunc {
-	return func(ctx context.Context) (context.Context, error) {
-		token, err := grpc_auth.AuthFromMD(ctx, "basic")
-		if err != nil {
-			return nil, fmt.Errorf("could not extract token from request header: %w", err)
-		}
+	unary = append(unary, grpc_ctxtags.UnaryServerInterceptor())
+	stream = append(stream, grpc_ctxtags.StreamServerInterceptor())
 
-		if err := validateBasicAuthToken(token, setServerToken); err != nil {
-			return nil, status.Errorf(codes.Unauthenticated, "invalid auth token: %v", err)
-		}
-
-		return ctx, nil
+	if s.authImpl != nil {
+		unary = append(unary, grpc_auth.UnaryServerInterceptor(s.authFunc()))
+		stream = append(stream, grpc_auth.StreamServerInterceptor(s.authFunc()))
+	} else {
+		unary = append(unary, grpc_prometheus.UnaryServerInterceptor)
+		stream = append(stream, grpc_prometheus.StreamServerInterceptor)
 	}
-}
 
-func validateBasicAuthToken(clientToken string, serverToken string) error {
-	data := base64.StdEncoding.EncodeToString([]byte(serverToken))
+	unary = append(unary, s.loggingUnaryInterceptor())
+	stream = append(stream, s.loggingStreamInterceptor())
+
+	unary = append(unary, grpc_recovery.UnaryServerInterceptor(s.recoveryOpts()...))
+	stream = append(stream, grpc_recovery.StreamServerInterceptor(s.recoveryOpts()...))
+
+	return append(opts,
+		grpc.StreamInterceptor(grpc_mw.ChainStreamServer(stream...)),
+		grpc.UnaryInterceptor(grpc_mw.ChainUnaryServer(unary...)),
+	)
+}
 
-	if strings.Compare(clientToken, string(data)) != 0 {
-		return errors.New("tokens do not match")
+func (s *FakeServer) recoveryOpts() []grpc_recovery.Option {
+	if s.recoveryHandler == nil {
+		return nil
 	}
 
-	return nil
+	return []grpc_recovery.Option{
+		grpc_recovery.WithRecoveryHandler(grpc_recovery.RecoveryHandlerFunc(s.recoveryHandler)),
+	}
 }
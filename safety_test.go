@@ -0,0 +1,47 @@
+package safety
+
+import (
+	"context"
+	"testing"
+
+	mvmv1 "github.com/weaveworks-liquidmetal/flintlock/api/services/microvm/v1alpha1"
+	"github.com/weaveworks-liquidmetal/flintlock/api/types"
+	"k8s.io/utils/pointer"
+)
+
+func TestListMicroVMs_NamespaceOnlyDoesNotPanic(t *testing.T) {
+	s := New()
+	s.Load(
+		&types.MicroVMSpec{Uid: pointer.String("uid-1"), Id: "vm-1", Namespace: "ns1"},
+		&types.MicroVMSpec{Uid: pointer.String("uid-2"), Id: "vm-2", Namespace: "ns2"},
+	)
+
+	resp, err := s.ListMicroVMs(context.Background(), &mvmv1.ListMicroVMsRequest{Namespace: "ns1"})
+	if err != nil {
+		t.Fatalf("ListMicroVMs() error = %v", err)
+	}
+
+	if len(resp.Microvm) != 1 || resp.Microvm[0].Spec.Id != "vm-1" {
+		t.Fatalf("ListMicroVMs() = %+v, want only vm-1", resp.Microvm)
+	}
+}
+
+func TestListMicroVMs_FiltersByName(t *testing.T) {
+	s := New()
+	s.Load(
+		&types.MicroVMSpec{Uid: pointer.String("uid-1"), Id: "vm-1", Namespace: "ns1"},
+		&types.MicroVMSpec{Uid: pointer.String("uid-2"), Id: "vm-2", Namespace: "ns1"},
+	)
+
+	resp, err := s.ListMicroVMs(context.Background(), &mvmv1.ListMicroVMsRequest{
+		Namespace: "ns1",
+		Name:      pointer.String("vm-2"),
+	})
+	if err != nil {
+		t.Fatalf("ListMicroVMs() error = %v", err)
+	}
+
+	if len(resp.Microvm) != 1 || resp.Microvm[0].Spec.Id != "vm-2" {
+		t.Fatalf("ListMicroVMs() = %+v, want only vm-2", resp.Microvm)
+	}
+}
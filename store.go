@@ -0,0 +1,168 @@
+package safety
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/weaveworks-liquidmetal/flintlock/api/types"
+)
+
+// ErrNotFound is returned by a Store's Get/Delete when no spec is saved
+// under the given uid.
+var ErrNotFound = errors.New("microvm not found")
+
+// EventType identifies what kind of mutation a Store Event represents.
+type EventType int
+
+const (
+	EventPut EventType = iota
+	EventDelete
+)
+
+// Event is a single mutation a Store reports to its watchers.
+type Event struct {
+	Type EventType
+	Spec *types.MicroVMSpec
+}
+
+// Store persists the microvm specs a FakeServer has been asked to create,
+// behind an interface so the in-memory default (MemoryStore) can be
+// swapped for something that survives a restart (BoltStore). Watch lets
+// ListMicroVMsStream observe every Put/Delete as it happens, so all
+// mutation paths converge on one event source.
+//
+// An etcd-backed Store, for sharing state across replicas of this fake
+// server, is not implemented here: it would need a real etcd cluster to
+// talk to, which is more infrastructure than a test double should demand
+// of its callers. BoltStore covers the restart-survival case that
+// motivated this interface; add an etcd implementation against the same
+// Store interface if a caller actually needs shared state.
+type Store interface {
+	Put(spec *types.MicroVMSpec) error
+	Get(uid string) (*types.MicroVMSpec, error)
+	Delete(uid string) error
+	List(namespace, name string) ([]*types.MicroVMSpec, error)
+	Watch(ctx context.Context) <-chan Event
+}
+
+// WithStore configures srv to persist microvm specs in store instead of
+// the default MemoryStore.
+func WithStore(store Store) Option {
+	return func(s *FakeServer) {
+		s.store = store
+	}
+}
+
+// storeImpl returns s's configured Store, defaulting to a MemoryStore.
+func (s *FakeServer) storeImpl() Store {
+	s.storeOnce.Do(func() {
+		if s.store == nil {
+			s.store = NewMemoryStore()
+		}
+	})
+
+	return s.store
+}
+
+func findSpec(specs []*types.MicroVMSpec, uid string) (*types.MicroVMSpec, bool) {
+	for _, spec := range specs {
+		if *spec.Uid == uid {
+			return spec, true
+		}
+	}
+
+	return nil, false
+}
+
+// MemoryStore is the default Store: microvm specs live only in memory and
+// are gone as soon as the process exits.
+type MemoryStore struct {
+	mu       sync.Mutex
+	specs    []*types.MicroVMSpec
+	watchers *broker[Event]
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{watchers: newBroker[Event]()}
+}
+
+func (m *MemoryStore) Put(spec *types.MicroVMSpec) error {
+	m.mu.Lock()
+	if existing, ok := findSpec(m.specs, *spec.Uid); ok {
+		for i, sp := range m.specs {
+			if sp == existing {
+				m.specs[i] = spec
+				break
+			}
+		}
+	} else {
+		m.specs = append(m.specs, spec)
+	}
+	m.mu.Unlock()
+
+	m.watchers.publish(Event{Type: EventPut, Spec: spec})
+
+	return nil
+}
+
+func (m *MemoryStore) Get(uid string) (*types.MicroVMSpec, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if spec, ok := findSpec(m.specs, uid); ok {
+		return spec, nil
+	}
+
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) Delete(uid string) error {
+	m.mu.Lock()
+	spec, ok := findSpec(m.specs, uid)
+	if !ok {
+		m.mu.Unlock()
+		return ErrNotFound
+	}
+
+	for i, sp := range m.specs {
+		if sp == spec {
+			m.specs[i] = m.specs[len(m.specs)-1]
+			m.specs = m.specs[:len(m.specs)-1]
+
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	m.watchers.publish(Event{Type: EventDelete, Spec: spec})
+
+	return nil
+}
+
+func (m *MemoryStore) List(namespace, name string) ([]*types.MicroVMSpec, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]*types.MicroVMSpec, 0, len(m.specs))
+
+	for _, spec := range m.specs {
+		if shouldReturn(spec, &name, namespace) {
+			out = append(out, spec)
+		}
+	}
+
+	return out, nil
+}
+
+func (m *MemoryStore) Watch(ctx context.Context) <-chan Event {
+	ch := m.watchers.subscribe(defaultStreamBufferSize)
+
+	go func() {
+		<-ctx.Done()
+		m.watchers.unsubscribe(ch)
+	}()
+
+	return ch
+}
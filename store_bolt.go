@@ -0,0 +1,134 @@
+package safety
+
+import (
+	"context"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/weaveworks-liquidmetal/flintlock/api/types"
+)
+
+var specsBucket = []byte("specs")
+
+// BoltStore is a Store backed by a bbolt database file, so microvm specs
+// saved through it survive a process restart. Each BoltStore owns its own
+// in-memory broker for Watch, same as MemoryStore, so its watchers see
+// only the Puts and Deletes made through that BoltStore, not those made
+// through another BoltStore opened on the same path.
+type BoltStore struct {
+	db       *bolt.DB
+	watchers *broker[Event]
+}
+
+// NewBoltStore opens (creating if necessary) a bbolt database at path and
+// returns a Store backed by it. Callers are responsible for calling
+// Close when done with it.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(specsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db, watchers: newBroker[Event]()}, nil
+}
+
+// Close releases the underlying bbolt database file.
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+func (b *BoltStore) Put(spec *types.MicroVMSpec) error {
+	data, err := proto.Marshal(spec)
+	if err != nil {
+		return err
+	}
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(specsBucket).Put([]byte(*spec.Uid), data)
+	}); err != nil {
+		return err
+	}
+
+	b.watchers.publish(Event{Type: EventPut, Spec: spec})
+
+	return nil
+}
+
+func (b *BoltStore) Get(uid string) (*types.MicroVMSpec, error) {
+	var spec *types.MicroVMSpec
+
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(specsBucket).Get([]byte(uid))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		spec = &types.MicroVMSpec{}
+
+		return proto.Unmarshal(data, spec)
+	}); err != nil {
+		return nil, err
+	}
+
+	return spec, nil
+}
+
+func (b *BoltStore) Delete(uid string) error {
+	spec, err := b.Get(uid)
+	if err != nil {
+		return err
+	}
+
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(specsBucket).Delete([]byte(uid))
+	}); err != nil {
+		return err
+	}
+
+	b.watchers.publish(Event{Type: EventDelete, Spec: spec})
+
+	return nil
+}
+
+func (b *BoltStore) List(namespace, name string) ([]*types.MicroVMSpec, error) {
+	var out []*types.MicroVMSpec
+
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(specsBucket).ForEach(func(_, data []byte) error {
+			spec := &types.MicroVMSpec{}
+			if err := proto.Unmarshal(data, spec); err != nil {
+				return err
+			}
+
+			if shouldReturn(spec, &name, namespace) {
+				out = append(out, spec)
+			}
+
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (b *BoltStore) Watch(ctx context.Context) <-chan Event {
+	ch := b.watchers.subscribe(defaultStreamBufferSize)
+
+	go func() {
+		<-ctx.Done()
+		b.watchers.unsubscribe(ch)
+	}()
+
+	return ch
+}
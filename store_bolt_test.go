@@ -0,0 +1,75 @@
+package safety
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/weaveworks-liquidmetal/flintlock/api/types"
+	"k8s.io/utils/pointer"
+)
+
+func TestBoltStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "specs.db")
+
+	b, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("NewBoltStore() error = %v", err)
+	}
+
+	spec := &types.MicroVMSpec{
+		Uid:       pointer.String("uid-1"),
+		Id:        "vm-1",
+		Namespace: "ns1",
+	}
+	if err := b.Put(spec); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	reopened, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("NewBoltStore() on reopen error = %v", err)
+	}
+	defer reopened.Close()
+
+	got, err := reopened.Get("uid-1")
+	if err != nil {
+		t.Fatalf("Get() after restart error = %v", err)
+	}
+
+	if got.Id != "vm-1" || got.Namespace != "ns1" {
+		t.Fatalf("Get() after restart = %+v, want Id=vm-1 Namespace=ns1", got)
+	}
+}
+
+func TestBoltStoreListFiltersByNamespaceAndName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "specs.db")
+
+	b, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("NewBoltStore() error = %v", err)
+	}
+	defer b.Close()
+
+	specs := []*types.MicroVMSpec{
+		{Uid: pointer.String("uid-1"), Id: "vm-1", Namespace: "ns1"},
+		{Uid: pointer.String("uid-2"), Id: "vm-2", Namespace: "ns2"},
+	}
+	for _, spec := range specs {
+		if err := b.Put(spec); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+
+	out, err := b.List("ns1", "")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	if len(out) != 1 || out[0].Id != "vm-1" {
+		t.Fatalf("List(\"ns1\", \"\") = %+v, want only vm-1", out)
+	}
+}
@@ -0,0 +1,197 @@
+package safety
+
+import (
+	"sync"
+	"time"
+
+	mvmv1 "github.com/weaveworks-liquidmetal/flintlock/api/services/microvm/v1alpha1"
+	"github.com/weaveworks-liquidmetal/flintlock/api/types"
+)
+
+// defaultStreamBufferSize is how many events a ListMicroVMsStream
+// subscriber can lag behind by before new events start blocking the
+// mutation that published them.
+const defaultStreamBufferSize = 16
+
+// WithStreamBufferSize configures how many lifecycle status events are
+// buffered per ListMicroVMsStream subscriber before a slow subscriber
+// starts applying backpressure to the calls that publish them. It does not
+// apply to the subscriber's Store.Watch channel, which carries the spec
+// creates/deletes and is always buffered at defaultStreamBufferSize.
+func WithStreamBufferSize(size int) Option {
+	return func(s *FakeServer) {
+		s.streamBufferSize = size
+	}
+}
+
+// WithStreamKeepalive configures how often ListMicroVMsStream sends an
+// empty keepalive message to each subscriber. The zero value (the
+// default) disables keepalives.
+func WithStreamKeepalive(d time.Duration) Option {
+	return func(s *FakeServer) {
+		s.streamKeepalive = d
+	}
+}
+
+// broker fans out events of type T to every subscriber over a bounded,
+// per-subscriber channel. It backs both the Store's Watch (Events) and
+// the fake server's lifecycle status updates (*types.MicroVM), so
+// ListMicroVMsStream can merge both into one subscriber-facing stream.
+type broker[T any] struct {
+	mu          sync.Mutex
+	subscribers map[chan T]chan struct{}
+}
+
+func newBroker[T any]() *broker[T] {
+	return &broker[T]{subscribers: map[chan T]chan struct{}{}}
+}
+
+func (b *broker[T]) subscribe(bufferSize int) chan T {
+	if bufferSize <= 0 {
+		bufferSize = defaultStreamBufferSize
+	}
+
+	ch := make(chan T, bufferSize)
+
+	b.mu.Lock()
+	b.subscribers[ch] = make(chan struct{})
+	b.mu.Unlock()
+
+	return ch
+}
+
+// unsubscribe removes ch and signals any publish currently blocked on
+// sending to it to give up, so a subscriber that goes away mid-publish
+// (client disconnect, deadline, slow reader) can never wedge the
+// publisher forever.
+func (b *broker[T]) unsubscribe(ch chan T) {
+	b.mu.Lock()
+	done, ok := b.subscribers[ch]
+	delete(b.subscribers, ch)
+	b.mu.Unlock()
+
+	if ok {
+		close(done)
+	}
+}
+
+// publish fans v out to every current subscriber. A subscriber whose
+// buffer is full blocks the publisher, so a slow ListMicroVMsStream
+// consumer applies backpressure to whatever RPC triggered the event
+// instead of silently missing it. A subscriber that unsubscribes while
+// its send is pending is given up on instead of blocking forever.
+func (b *broker[T]) publish(v T) {
+	b.mu.Lock()
+	dones := make(map[chan T]chan struct{}, len(b.subscribers))
+	for ch, done := range b.subscribers {
+		dones[ch] = done
+	}
+	b.mu.Unlock()
+
+	for ch, done := range dones {
+		select {
+		case ch <- v:
+		case <-done:
+		}
+	}
+}
+
+func (s *FakeServer) statusBroker() *broker[*types.MicroVM] {
+	s.brokerOnce.Do(func() {
+		s.streamBroker = newBroker[*types.MicroVM]()
+	})
+
+	return s.streamBroker
+}
+
+// publishState fans out uid's current spec at the given status to any
+// ListMicroVMsStream subscribers. It is a no-op if uid is no longer saved
+// (e.g. it raced with a delete). Spec mutations (create/delete) reach
+// subscribers via the Store's Watch instead; this covers status changes
+// made by the lifecycle state machine and by Advance, including
+// DeleteMicroVM's move to DELETING.
+func (s *FakeServer) publishState(uid string, state types.MicroVMStatus_MicroVMState) {
+	spec, err := s.storeImpl().Get(uid)
+	if err != nil {
+		return
+	}
+
+	s.statusBroker().publish(&types.MicroVM{
+		Spec:   spec,
+		Status: &types.MicroVMStatus{State: state},
+	})
+}
+
+// ListMicroVMsStream sends a snapshot of every currently saved microvm
+// matching req, then keeps the stream open and pushes incremental events
+// as CreateMicroVM/DeleteMicroVM mutate the Store or the lifecycle state
+// machine advances a tracked microvm's status. Both mutation paths
+// converge here: Store.Watch for spec creates/deletes, and the status
+// broker for status changes made by the lifecycle or by Advance.
+func (s *FakeServer) ListMicroVMsStream(
+	req *mvmv1.ListMicroVMsRequest,
+	streamServer mvmv1.MicroVM_ListMicroVMsStreamServer,
+) error {
+	ctx := streamServer.Context()
+	name := req.GetName()
+
+	statusCh := s.statusBroker().subscribe(s.streamBufferSize)
+	defer s.statusBroker().unsubscribe(statusCh)
+
+	storeCh := s.storeImpl().Watch(ctx)
+
+	specs, err := s.storeImpl().List(req.Namespace, req.GetName())
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range specs {
+		m := &types.MicroVM{
+			Spec:   spec,
+			Status: &types.MicroVMStatus{State: s.stateFor(*spec.Uid)},
+		}
+
+		if err := streamServer.Send(&mvmv1.ListMessage{Microvm: m}); err != nil {
+			return err
+		}
+	}
+
+	var keepalive <-chan time.Time
+	if s.streamKeepalive > 0 {
+		ticker := time.NewTicker(s.streamKeepalive)
+		defer ticker.Stop()
+		keepalive = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m := <-statusCh:
+			if !shouldReturn(m.Spec, &name, req.Namespace) {
+				continue
+			}
+
+			if err := streamServer.Send(&mvmv1.ListMessage{Microvm: m}); err != nil {
+				return err
+			}
+		case ev := <-storeCh:
+			if !shouldReturn(ev.Spec, &name, req.Namespace) {
+				continue
+			}
+
+			m := &types.MicroVM{
+				Spec:   ev.Spec,
+				Status: &types.MicroVMStatus{State: s.stateForEvent(ev)},
+			}
+
+			if err := streamServer.Send(&mvmv1.ListMessage{Microvm: m}); err != nil {
+				return err
+			}
+		case <-keepalive:
+			if err := streamServer.Send(&mvmv1.ListMessage{}); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package safety
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mvmv1 "github.com/weaveworks-liquidmetal/flintlock/api/services/microvm/v1alpha1"
+	"github.com/weaveworks-liquidmetal/flintlock/api/types"
+	"google.golang.org/grpc"
+	"k8s.io/utils/pointer"
+)
+
+// fakeListStreamServer implements mvmv1.MicroVM_ListMicroVMsStreamServer
+// over a context and a channel, so tests can drive ListMicroVMsStream
+// without a real gRPC connection.
+type fakeListStreamServer struct {
+	grpc.ServerStream
+	ctx context.Context
+	out chan *mvmv1.ListMessage
+}
+
+func (f *fakeListStreamServer) Context() context.Context { return f.ctx }
+
+func (f *fakeListStreamServer) Send(m *mvmv1.ListMessage) error {
+	f.out <- m
+	return nil
+}
+
+func TestListMicroVMsStream_NamespaceOnlyDoesNotPanic(t *testing.T) {
+	s := New()
+	s.Load(&types.MicroVMSpec{
+		Uid:       pointer.String("uid-1"),
+		Id:        "vm-1",
+		Namespace: "ns1",
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream := &fakeListStreamServer{ctx: ctx, out: make(chan *mvmv1.ListMessage, 1)}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.ListMicroVMsStream(&mvmv1.ListMicroVMsRequest{Namespace: "ns1"}, stream)
+	}()
+
+	select {
+	case msg := <-stream.out:
+		if msg.Microvm.Spec.Id != "vm-1" {
+			t.Fatalf("got microvm %q, want vm-1", msg.Microvm.Spec.Id)
+		}
+	case err := <-errCh:
+		t.Fatalf("ListMicroVMsStream returned early: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for snapshot message")
+	}
+}
+
+func TestListMicroVMsStream_AdvanceDeliversStatus(t *testing.T) {
+	s := New()
+	s.Load(&types.MicroVMSpec{
+		Uid:       pointer.String("uid-1"),
+		Id:        "vm-1",
+		Namespace: "ns1",
+	})
+	s.trackState("uid-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream := &fakeListStreamServer{ctx: ctx, out: make(chan *mvmv1.ListMessage, 4)}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.ListMicroVMsStream(&mvmv1.ListMicroVMsRequest{Namespace: "ns1"}, stream)
+	}()
+
+	// Drain the initial snapshot before advancing the lifecycle state.
+	select {
+	case <-stream.out:
+	case err := <-errCh:
+		t.Fatalf("ListMicroVMsStream returned early: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for snapshot message")
+	}
+
+	s.Advance("uid-1", types.MicroVMStatus_CREATED)
+
+	select {
+	case msg := <-stream.out:
+		if msg.Microvm.Status.State != types.MicroVMStatus_CREATED {
+			t.Fatalf("got state %v, want CREATED", msg.Microvm.Status.State)
+		}
+	case err := <-errCh:
+		t.Fatalf("ListMicroVMsStream returned early: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for status update")
+	}
+}
+
+func TestListMicroVMsStream_DeleteWhileStreamingReportsDeleting(t *testing.T) {
+	// Run several iterations to flush out the untrackState/publish race
+	// rather than relying on a single lucky (or unlucky) ordering.
+	for i := 0; i < 20; i++ {
+		s := New()
+		// Loaded (rather than created) so no default-lifecycle transition
+		// fires in the background and muddies the event stream below.
+		s.Load(&types.MicroVMSpec{
+			Uid:       pointer.String("uid-1"),
+			Id:        "vm-1",
+			Namespace: "ns1",
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		stream := &fakeListStreamServer{ctx: ctx, out: make(chan *mvmv1.ListMessage, 4)}
+
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- s.ListMicroVMsStream(&mvmv1.ListMicroVMsRequest{Namespace: "ns1"}, stream)
+		}()
+
+		// Drain the initial snapshot before deleting.
+		select {
+		case <-stream.out:
+		case err := <-errCh:
+			t.Fatalf("ListMicroVMsStream returned early: %v", err)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for snapshot message")
+		}
+
+		if _, err := s.DeleteMicroVM(context.Background(), &mvmv1.DeleteMicroVMRequest{Uid: "uid-1"}); err != nil {
+			t.Fatalf("DeleteMicroVM() error = %v", err)
+		}
+
+		// DeleteMicroVM publishes two events for this uid: the Advance to
+		// DELETING on the status broker, and the EventDelete on the store's
+		// Watch once removeSpec removes it. Every one of them must report
+		// DELETING; none may race untrackState into the stateFor fallback.
+		seen := 0
+		for seen < 2 {
+			select {
+			case msg := <-stream.out:
+				seen++
+				if msg.Microvm.Status.State != types.MicroVMStatus_DELETING {
+					t.Fatalf("iteration %d: event %d got state %v, want DELETING", i, seen, msg.Microvm.Status.State)
+				}
+			case err := <-errCh:
+				t.Fatalf("ListMicroVMsStream returned early: %v", err)
+			case <-time.After(time.Second):
+				t.Fatalf("iteration %d: timed out waiting for delete events, saw %d of 2", i, seen)
+			}
+		}
+
+		cancel()
+	}
+}
+
+func TestBrokerPublishAfterUnsubscribeDoesNotBlock(t *testing.T) {
+	b := newBroker[int]()
+
+	ch := b.subscribe(0)
+	b.unsubscribe(ch)
+
+	done := make(chan struct{})
+	go func() {
+		b.publish(1)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked on a channel that had already unsubscribed")
+	}
+}
@@ -0,0 +1,301 @@
+package safety
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mvmv1 "github.com/weaveworks-liquidmetal/flintlock/api/services/microvm/v1alpha1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// isHandlerNotFound reports whether err is GetMicroVM's own "microvm not
+// found" error, i.e. the call got through the transport to the handler,
+// as opposed to a transport or handshake failure.
+func isHandlerNotFound(err error) bool {
+	return err != nil && strings.Contains(status.Convert(err).Message(), "microvm not found")
+}
+
+// dialTLS starts s on a loopback listener secured with s's configured TLS
+// and returns a client dialed against it with clientConfig.
+func dialTLS(t *testing.T, s *FakeServer, clientConfig *tls.Config) *grpc.ClientConn {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+
+	s.start(l, s.serverOptions())
+	t.Cleanup(func() { _ = s.Stop() })
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(clientConfig)))
+	if err != nil {
+		t.Fatalf("grpc.Dial() error = %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func TestGenerateSelfSignedCertHandshake(t *testing.T) {
+	serverConfig, clientConfig, err := GenerateSelfSignedCert("127.0.0.1")
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCert() error = %v", err)
+	}
+
+	s := New(WithTLSConfig(serverConfig))
+	conn := dialTLS(t, s, clientConfig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// GetMicroVM erroring with "not found" (a business error from the
+	// handler), rather than a transport/handshake error, confirms the TLS
+	// connection was established and the call actually reached the server.
+	if _, err := mvmv1.NewMicroVMClient(conn).GetMicroVM(ctx, &mvmv1.GetMicroVMRequest{Uid: "nope"}); !isHandlerNotFound(err) {
+		t.Fatalf("GetMicroVM() error = %v, want the handler's not-found error", err)
+	}
+}
+
+func TestNewServerTLSConfigFromFiles(t *testing.T) {
+	serverConfig, clientConfig, err := GenerateSelfSignedCert("127.0.0.1")
+	if err != nil {
+		t.Fatalf("GenerateSelfSignedCert() error = %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, serverConfig.Certificates[0])
+
+	cfg, err := NewServerTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("NewServerTLSConfig() error = %v", err)
+	}
+
+	s := New(WithTLSConfig(cfg))
+	conn := dialTLS(t, s, clientConfig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := mvmv1.NewMicroVMClient(conn).GetMicroVM(ctx, &mvmv1.GetMicroVMRequest{Uid: "nope"}); !isHandlerNotFound(err) {
+		t.Fatalf("GetMicroVM() error = %v, want the handler's not-found error", err)
+	}
+}
+
+func TestNewServerTLSConfigMTLSRejectsMissingClientCert(t *testing.T) {
+	ca, serverCert, _ := generateMTLSChain(t, "127.0.0.1")
+
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, serverCert)
+	caFile := writeCertPEM(t, dir, "ca.pem", ca.certPEM)
+
+	cfg, err := NewServerTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("NewServerTLSConfig() error = %v", err)
+	}
+
+	s := New(WithTLSConfig(cfg))
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.certPEM)
+
+	// A client that trusts the server's CA but presents no client
+	// certificate of its own must be rejected by RequireAndVerifyClientCert.
+	conn := dialTLS(t, s, &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := mvmv1.NewMicroVMClient(conn).GetMicroVM(ctx, &mvmv1.GetMicroVMRequest{Uid: "nope"}); err == nil || isHandlerNotFound(err) {
+		t.Fatalf("GetMicroVM() error = %v without a client certificate, want the mTLS handshake to fail", err)
+	}
+}
+
+func TestNewServerTLSConfigMTLSAcceptsValidClientCert(t *testing.T) {
+	ca, serverCert, clientCert := generateMTLSChain(t, "127.0.0.1")
+
+	dir := t.TempDir()
+	certFile, keyFile := writeKeyPair(t, dir, serverCert)
+	caFile := writeCertPEM(t, dir, "ca.pem", ca.certPEM)
+
+	cfg, err := NewServerTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("NewServerTLSConfig() error = %v", err)
+	}
+
+	s := New(WithTLSConfig(cfg))
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca.certPEM)
+
+	conn := dialTLS(t, s, &tls.Config{
+		RootCAs:      pool,
+		ServerName:   "127.0.0.1",
+		Certificates: []tls.Certificate{clientCert},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := mvmv1.NewMicroVMClient(conn).GetMicroVM(ctx, &mvmv1.GetMicroVMRequest{Uid: "nope"}); !isHandlerNotFound(err) {
+		t.Fatalf("GetMicroVM() error = %v, want the handler's not-found error", err)
+	}
+}
+
+// caFixture bundles a CA keypair with its PEM-encoded certificate, so
+// generateMTLSChain's caller can both trust it (RootCAs/clientCAFile) and
+// sign further certificates with it.
+type caFixture struct {
+	key     *ecdsa.PrivateKey
+	cert    *x509.Certificate
+	certPEM []byte
+}
+
+// generateMTLSChain builds a minimal CA plus a server and client leaf
+// certificate signed by it, for tests that need a real verifiable chain
+// rather than GenerateSelfSignedCert's single self-signed cert.
+func generateMTLSChain(t *testing.T, host string) (ca caFixture, serverCert, clientCert tls.Certificate) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating CA key: %v", err)
+	}
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          newSerial(t),
+		Subject:               pkix.Name{Organization: []string{"safety fake flintlock test CA"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating CA certificate: %v", err)
+	}
+
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("parsing CA certificate: %v", err)
+	}
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+
+	serverCert = signLeaf(t, caCert, caKey, host, x509.ExtKeyUsageServerAuth)
+	clientCert = signLeaf(t, caCert, caKey, "test-client", x509.ExtKeyUsageClientAuth)
+
+	return caFixture{key: caKey, cert: caCert, certPEM: caPEM}, serverCert, clientCert
+}
+
+func signLeaf(t *testing.T, caCert *x509.Certificate, caKey *ecdsa.PrivateKey, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating leaf key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: newSerial(t),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = append(template.IPAddresses, ip)
+	} else {
+		template.DNSNames = append(template.DNSNames, name)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("creating leaf certificate: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshalling leaf key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("building leaf keypair: %v", err)
+	}
+
+	return cert
+}
+
+func newSerial(t *testing.T) *big.Int {
+	t.Helper()
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		t.Fatalf("generating serial number: %v", err)
+	}
+
+	return serial
+}
+
+func writeKeyPair(t *testing.T, dir string, cert tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+
+	key, ok := cert.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		t.Fatalf("cert.PrivateKey is %T, want *ecdsa.PrivateKey", cert.PrivateKey)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshalling key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+
+	certFile = writeCertPEM(t, dir, "cert.pem", certPEM)
+	keyFile = filepath.Join(dir, "key.pem")
+	writeFile(t, keyFile, keyPEM)
+
+	return certFile, keyFile
+}
+
+func writeCertPEM(t *testing.T, dir, name string, pemBytes []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	writeFile(t, path, pemBytes)
+
+	return path
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("writing %s: %v", path, err)
+	}
+}